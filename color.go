@@ -0,0 +1,64 @@
+package json
+
+// ColorScheme defines the ANSI escape sequences to wrap around each class of
+// JSON token for colorized output. Header is meant to be written before the
+// token, Footer after it, to reset back to the default terminal style.
+type ColorScheme struct {
+	Key        ColorToken
+	String     ColorToken
+	Number     ColorToken
+	Bool       ColorToken
+	Null       ColorToken
+	Punctuator ColorToken
+}
+
+// ColorToken is the escape sequence pair wrapped around a single emitted
+// token.
+type ColorToken struct {
+	Header []byte
+	Footer []byte
+}
+
+var colorReset = []byte("\x1b[0m")
+
+func newColorToken(code string) ColorToken {
+	return ColorToken{
+		Header: []byte("\x1b[" + code + "m"),
+		Footer: colorReset,
+	}
+}
+
+// DefaultColorScheme is used by WithColor when no scheme is given: blue keys,
+// green strings, yellow numbers, magenta booleans/null and plain structural
+// punctuation.
+var DefaultColorScheme = &ColorScheme{
+	Key:        newColorToken("34"),
+	String:     newColorToken("32"),
+	Number:     newColorToken("33"),
+	Bool:       newColorToken("35"),
+	Null:       newColorToken("35"),
+	Punctuator: newColorToken("0"),
+}
+
+// WithColor records scheme (or DefaultColorScheme if scheme is nil) on
+// EncodeOptions.ColorScheme for a future colorized encoder dispatch to use.
+//
+// It does not yet change Marshal's output: turning ColorScheme into
+// ANSI-escaped JSON requires dispatching to color-aware VM variants
+// (mirroring vm/ and vm_indent/ the way the request describes) based on
+// Option.Flag, and neither that dispatch nor the vm_color/vm_color_indent
+// packages exist in this chunk. EncodeOptions.ColorScheme is set here and
+// not read anywhere yet. Because of that, color_test.go only covers this
+// plumbing (newColorToken, DefaultColorScheme, WithColor's defaulting and
+// storage); the behavioral tests the original request asked for — escape
+// sequences only emitted under the flag, indented color output still valid
+// JSON after stripping SGR — need the VM dispatch above and belong with it,
+// not here.
+func WithColor(scheme *ColorScheme) EncodeOption {
+	if scheme == nil {
+		scheme = DefaultColorScheme
+	}
+	return func(opts *EncodeOptions) {
+		opts.ColorScheme = scheme
+	}
+}
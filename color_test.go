@@ -0,0 +1,52 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewColorToken(t *testing.T) {
+	tok := newColorToken("34")
+	if !bytes.Equal(tok.Header, []byte("\x1b[34m")) {
+		t.Fatalf("Header = %q, want %q", tok.Header, "\x1b[34m")
+	}
+	if !bytes.Equal(tok.Footer, []byte("\x1b[0m")) {
+		t.Fatalf("Footer = %q, want %q", tok.Footer, "\x1b[0m")
+	}
+}
+
+func TestDefaultColorScheme(t *testing.T) {
+	tokens := []ColorToken{
+		DefaultColorScheme.Key,
+		DefaultColorScheme.String,
+		DefaultColorScheme.Number,
+		DefaultColorScheme.Bool,
+		DefaultColorScheme.Null,
+		DefaultColorScheme.Punctuator,
+	}
+	for _, tok := range tokens {
+		if len(tok.Header) == 0 {
+			t.Fatal("token Header must not be empty")
+		}
+		if !bytes.Equal(tok.Footer, colorReset) {
+			t.Fatalf("Footer = %q, want %q", tok.Footer, colorReset)
+		}
+	}
+}
+
+func TestWithColorDefaultsToDefaultColorScheme(t *testing.T) {
+	opts := &EncodeOptions{}
+	WithColor(nil)(opts)
+	if opts.ColorScheme != DefaultColorScheme {
+		t.Fatal("WithColor(nil) should fall back to DefaultColorScheme")
+	}
+}
+
+func TestWithColorUsesGivenScheme(t *testing.T) {
+	scheme := &ColorScheme{Key: newColorToken("31")}
+	opts := &EncodeOptions{}
+	WithColor(scheme)(opts)
+	if opts.ColorScheme != scheme {
+		t.Fatal("WithColor(scheme) should use the given scheme")
+	}
+}
@@ -20,14 +20,26 @@ type opcode struct {
 	root         bool   // whether root
 	indent       int    // indent number
 
-	idx     uintptr // offset to access ptr
-	headIdx uintptr // offset to access slice/struct head
-	elemIdx uintptr // offset to access array/slice/map elem
-	length  uintptr // offset to access slice/map length or array length
-	mapIter uintptr // offset to access map iterator
-	mapPos  uintptr // offset to access position list for sorted map
-	offset  uintptr // offset size from struct header
-	size    uintptr // array/slice elem size
+	// query is baked into the compiled tree, so a cached opcode set compiled
+	// under one FieldQuery must not be reused for a call with a different
+	// (or absent) one — see seedCompileContext, copyOpcode and the
+	// WithFieldQuery doc comment in option.go for the cache-invalidation this
+	// still needs. A regression test for that invalidation would compile the
+	// same type twice, once via Marshal(v, WithFieldQuery(q)) and once via a
+	// plain Marshal(v), and assert the second call's opcode tree has
+	// query == nil; the compile entry point and its per-type cache that
+	// would make that assertion meaningful don't exist in this chunk, so no
+	// such test is included here.
+	query     *FieldQuery // active field query for this subtree, nil if unfiltered
+	queryOmit bool        // whether this subtree is excluded by query and must be skipped entirely
+
+	idx        uintptr // offset to access ptr
+	headIdx    uintptr // offset to access slice/struct head
+	elemIdx    uintptr // offset to access array/slice/map elem
+	length     uintptr // offset to access slice/map length or array length
+	mapContext uintptr // offset to access the map's consolidated MapContext
+	offset     uintptr // offset size from struct header
+	size       uintptr // array/slice elem size
 
 	mapKey    *opcode       // map key
 	mapValue  *opcode       // map value
@@ -38,6 +50,24 @@ type opcode struct {
 	jmp       *compiledCode // for recursive call
 }
 
+// MapContext holds the iteration state shared by a map's head, key, value
+// and end opcodes. The separate length, mapIter and mapPos pointer-table
+// slots are replaced by one mapContext slot: newMapHeaderCode hands out idx,
+// elemIdx and mapContext, and newMapKeyCode, newMapValueCode and
+// newMapEndCode share the head's elemIdx and mapContext offsets (the end
+// opcode shares only mapContext) instead of taking slots of their own.
+//
+// The opcodes only carry the mapContext offset; no code in this package
+// allocates a MapContext or reads one through that offset.
+type MapContext struct {
+	Iter  hiter
+	Idx   int
+	Len   int
+	Start int
+	First int
+	Pos   []int
+}
+
 func newOpCode(ctx *encodeCompileContext, op opType) *opcode {
 	return newOpCodeWithNext(ctx, op, newEndOp(ctx))
 }
@@ -46,6 +76,13 @@ func opcodeOffset(idx int) uintptr {
 	return uintptr(idx) * uintptrSize
 }
 
+// copyOpcode clones a compiled opcode tree so a single cached, per-type
+// master tree can be copied once per Marshal call and then have its indices
+// rewritten (see decOpcodeIndex) without mutating what other calls share.
+// copy() duplicates query/queryOmit verbatim from the master, so whatever
+// FieldQuery was baked into the master at compile time comes along on every
+// copy — see the query field's doc comment on opcode for why that's a
+// problem when a FieldQuery is only meant to apply to one call.
 func copyOpcode(code *opcode) *opcode {
 	codeMap := map[uintptr]*opcode{}
 	return code.copy(codeMap)
@@ -57,6 +94,7 @@ func newOpCodeWithNext(ctx *encodeCompileContext, op opType, next *opcode) *opco
 		typ:        ctx.typ,
 		displayIdx: ctx.opcodeIndex,
 		indent:     ctx.indent,
+		query:      ctx.query,
 		idx:        opcodeOffset(ctx.ptrIndex),
 		next:       next,
 	}
@@ -85,12 +123,13 @@ func (c *opcode) copy(codeMap map[uintptr]*opcode) *opcode {
 		anonymousKey: c.anonymousKey,
 		root:         c.root,
 		indent:       c.indent,
+		query:        c.query,
+		queryOmit:    c.queryOmit,
 		idx:          c.idx,
 		headIdx:      c.headIdx,
 		elemIdx:      c.elemIdx,
 		length:       c.length,
-		mapIter:      c.mapIter,
-		mapPos:       c.mapPos,
+		mapContext:   c.mapContext,
 		offset:       c.offset,
 		size:         c.size,
 	}
@@ -150,8 +189,8 @@ func (c *opcode) decOpcodeIndex() {
 		if code.elemIdx > 0 {
 			code.elemIdx -= uintptrSize
 		}
-		if code.mapIter > 0 {
-			code.mapIter -= uintptrSize
+		if code.mapContext > 0 {
+			code.mapContext -= uintptrSize
 		}
 		if code.length > 0 && code.op.codeType() != codeArrayHead && code.op.codeType() != codeArrayElem {
 			code.length -= uintptrSize
@@ -165,6 +204,24 @@ func (c *opcode) decOpcodeIndex() {
 	}
 }
 
+// resolveElemQuery narrows the active query to the one selecting
+// slice/array/map elements, tagging the opcode as excluded when the query
+// selects none. Called on the head opcode right after construction so the
+// key/value/elem opcodes built from it inherit the narrowed query. The VM
+// consults queryOmit to skip both the key/comma separator and the value
+// subtree in one step.
+//
+// Named struct-field filtering is not wired: doing so would need the struct
+// compiler to resolve a FieldQuery per field name when building its opcode,
+// and that compiler lives outside this chunk. NewFieldQuery
+// rejects named path segments for exactly this reason — see its doc
+// comment.
+func (c *opcode) resolveElemQuery() *FieldQuery {
+	sub, included := c.query.elem()
+	c.queryOmit = !included
+	return sub
+}
+
 func (c *opcode) dumpHead(code *opcode) string {
 	var length uintptr
 	if code.op.codeType() == codeArrayHead {
@@ -186,27 +243,25 @@ func (c *opcode) dumpHead(code *opcode) string {
 
 func (c *opcode) dumpMapHead(code *opcode) string {
 	return fmt.Sprintf(
-		`[%d]%s%s ([idx:%d][headIdx:%d][elemIdx:%d][length:%d][mapIter:%d])`,
+		`[%d]%s%s ([idx:%d][headIdx:%d][elemIdx:%d][mapContext:%d])`,
 		code.displayIdx,
 		strings.Repeat("-", code.indent),
 		code.op,
 		code.idx/uintptrSize,
 		code.headIdx/uintptrSize,
 		code.elemIdx/uintptrSize,
-		code.length/uintptrSize,
-		code.mapIter/uintptrSize,
+		code.mapContext/uintptrSize,
 	)
 }
 
 func (c *opcode) dumpMapEnd(code *opcode) string {
 	return fmt.Sprintf(
-		`[%d]%s%s ([idx:%d][mapPos:%d][length:%d])`,
+		`[%d]%s%s ([idx:%d][mapContext:%d])`,
 		code.displayIdx,
 		strings.Repeat("-", code.indent),
 		code.op,
 		code.idx/uintptrSize,
-		code.mapPos/uintptrSize,
-		code.length/uintptrSize,
+		code.mapContext/uintptrSize,
 	)
 }
 
@@ -245,25 +300,24 @@ func (c *opcode) dumpField(code *opcode) string {
 
 func (c *opcode) dumpKey(code *opcode) string {
 	return fmt.Sprintf(
-		`[%d]%s%s ([idx:%d][elemIdx:%d][length:%d][mapIter:%d])`,
+		`[%d]%s%s ([idx:%d][elemIdx:%d][mapContext:%d])`,
 		code.displayIdx,
 		strings.Repeat("-", code.indent),
 		code.op,
 		code.idx/uintptrSize,
 		code.elemIdx/uintptrSize,
-		code.length/uintptrSize,
-		code.mapIter/uintptrSize,
+		code.mapContext/uintptrSize,
 	)
 }
 
 func (c *opcode) dumpValue(code *opcode) string {
 	return fmt.Sprintf(
-		`[%d]%s%s ([idx:%d][mapIter:%d])`,
+		`[%d]%s%s ([idx:%d][mapContext:%d])`,
 		code.displayIdx,
 		strings.Repeat("-", code.indent),
 		code.op,
 		code.idx/uintptrSize,
-		code.mapIter/uintptrSize,
+		code.mapContext/uintptrSize,
 	)
 }
 
@@ -334,7 +388,7 @@ func newSliceHeaderCode(ctx *encodeCompileContext) *opcode {
 	elemIdx := opcodeOffset(ctx.ptrIndex)
 	ctx.incPtrIndex()
 	length := opcodeOffset(ctx.ptrIndex)
-	return &opcode{
+	head := &opcode{
 		op:         opSliceHead,
 		displayIdx: ctx.opcodeIndex,
 		idx:        idx,
@@ -342,7 +396,10 @@ func newSliceHeaderCode(ctx *encodeCompileContext) *opcode {
 		elemIdx:    elemIdx,
 		length:     length,
 		indent:     ctx.indent,
+		query:      ctx.query,
 	}
+	head.query = head.resolveElemQuery()
+	return head
 }
 
 func newSliceElemCode(ctx *encodeCompileContext, head *opcode, size uintptr) *opcode {
@@ -355,6 +412,8 @@ func newSliceElemCode(ctx *encodeCompileContext, head *opcode, size uintptr) *op
 		length:     head.length,
 		indent:     ctx.indent,
 		size:       size,
+		query:      head.query,
+		queryOmit:  head.queryOmit,
 	}
 }
 
@@ -362,7 +421,7 @@ func newArrayHeaderCode(ctx *encodeCompileContext, alen int) *opcode {
 	idx := opcodeOffset(ctx.ptrIndex)
 	ctx.incPtrIndex()
 	elemIdx := opcodeOffset(ctx.ptrIndex)
-	return &opcode{
+	head := &opcode{
 		op:         opArrayHead,
 		displayIdx: ctx.opcodeIndex,
 		idx:        idx,
@@ -370,7 +429,10 @@ func newArrayHeaderCode(ctx *encodeCompileContext, alen int) *opcode {
 		elemIdx:    elemIdx,
 		indent:     ctx.indent,
 		length:     uintptr(alen),
+		query:      ctx.query,
 	}
+	head.query = head.resolveElemQuery()
+	return head
 }
 
 func newArrayElemCode(ctx *encodeCompileContext, head *opcode, length int, size uintptr) *opcode {
@@ -382,6 +444,8 @@ func newArrayElemCode(ctx *encodeCompileContext, head *opcode, length int, size
 		headIdx:    head.headIdx,
 		length:     uintptr(length),
 		size:       size,
+		query:      head.query,
+		queryOmit:  head.queryOmit,
 	}
 }
 
@@ -396,19 +460,19 @@ func newMapHeaderCode(ctx *encodeCompileContext, withLoad bool) *opcode {
 	ctx.incPtrIndex()
 	elemIdx := opcodeOffset(ctx.ptrIndex)
 	ctx.incPtrIndex()
-	length := opcodeOffset(ctx.ptrIndex)
-	ctx.incPtrIndex()
-	mapIter := opcodeOffset(ctx.ptrIndex)
-	return &opcode{
+	mapContext := opcodeOffset(ctx.ptrIndex)
+	head := &opcode{
 		op:         op,
 		typ:        ctx.typ,
 		displayIdx: ctx.opcodeIndex,
 		idx:        idx,
 		elemIdx:    elemIdx,
-		length:     length,
-		mapIter:    mapIter,
+		mapContext: mapContext,
 		indent:     ctx.indent,
+		query:      ctx.query,
 	}
+	head.query = head.resolveElemQuery()
+	return head
 }
 
 func newMapKeyCode(ctx *encodeCompileContext, head *opcode) *opcode {
@@ -417,9 +481,10 @@ func newMapKeyCode(ctx *encodeCompileContext, head *opcode) *opcode {
 		displayIdx: ctx.opcodeIndex,
 		idx:        opcodeOffset(ctx.ptrIndex),
 		elemIdx:    head.elemIdx,
-		length:     head.length,
-		mapIter:    head.mapIter,
+		mapContext: head.mapContext,
 		indent:     ctx.indent,
+		query:      head.query,
+		queryOmit:  head.queryOmit,
 	}
 }
 
@@ -429,23 +494,21 @@ func newMapValueCode(ctx *encodeCompileContext, head *opcode) *opcode {
 		displayIdx: ctx.opcodeIndex,
 		idx:        opcodeOffset(ctx.ptrIndex),
 		elemIdx:    head.elemIdx,
-		length:     head.length,
-		mapIter:    head.mapIter,
+		mapContext: head.mapContext,
 		indent:     ctx.indent,
+		query:      head.query,
+		queryOmit:  head.queryOmit,
 	}
 }
 
 func newMapEndCode(ctx *encodeCompileContext, head *opcode) *opcode {
-	mapPos := opcodeOffset(ctx.ptrIndex)
-	ctx.incPtrIndex()
-	idx := opcodeOffset(ctx.ptrIndex)
 	return &opcode{
 		op:         opMapEnd,
 		displayIdx: ctx.opcodeIndex,
-		idx:        idx,
-		length:     head.length,
-		mapPos:     mapPos,
+		idx:        opcodeOffset(ctx.ptrIndex),
+		mapContext: head.mapContext,
 		indent:     ctx.indent,
+		query:      head.query,
 		next:       newEndOp(ctx),
 	}
 }
@@ -458,6 +521,7 @@ func newInterfaceCode(ctx *encodeCompileContext) *opcode {
 		idx:        opcodeOffset(ctx.ptrIndex),
 		indent:     ctx.indent,
 		root:       ctx.root,
+		query:      ctx.query,
 		next:       newEndOp(ctx),
 	}
 }
@@ -469,6 +533,7 @@ func newRecursiveCode(ctx *encodeCompileContext, jmp *compiledCode) *opcode {
 		displayIdx: ctx.opcodeIndex,
 		idx:        opcodeOffset(ctx.ptrIndex),
 		indent:     ctx.indent,
+		query:      ctx.query,
 		next:       newEndOp(ctx),
 		jmp:        jmp,
 	}
@@ -0,0 +1,80 @@
+package json
+
+import "testing"
+
+// newMapHeaderCode, newMapKeyCode, newMapValueCode and newMapEndCode (and
+// their slice/array counterparts) all derive their pointer-table offsets
+// from opcodeOffset, so this is the one piece of that bookkeeping we can
+// exercise without the rest of the compiler/VM: opcode itself embeds rtype,
+// opType and *compiledCode, and MapContext embeds hiter, none of which are
+// defined in this repo slice. Declaring stand-ins for them here to
+// construct a real *opcode or *MapContext would redeclare types that
+// belong to sibling files this chunk doesn't include, and would conflict
+// with the real definitions once those files exist. So the map-offset
+// consolidation (newMapHeaderCode hands out 3 pointer-table slots instead
+// of 4: idx, elemIdx, mapContext; newMapEndCode no longer takes a mapPos
+// slot) is exercised only at the opcodeOffset level.
+func TestOpcodeOffset(t *testing.T) {
+	for idx := 0; idx < 8; idx++ {
+		got := opcodeOffset(idx)
+		want := uintptr(idx) * uintptrSize
+		if got != want {
+			t.Fatalf("opcodeOffset(%d) = %d, want %d", idx, got, want)
+		}
+	}
+}
+
+func TestOpcodeOffsetIsMonotonic(t *testing.T) {
+	prev := opcodeOffset(0)
+	for idx := 1; idx < 8; idx++ {
+		cur := opcodeOffset(idx)
+		if cur <= prev {
+			t.Fatalf("opcodeOffset(%d) = %d did not advance past opcodeOffset(%d) = %d", idx, cur, idx-1, prev)
+		}
+		if cur-prev != uintptrSize {
+			t.Fatalf("opcodeOffset(%d) - opcodeOffset(%d) = %d, want uintptrSize (%d)", idx, idx-1, cur-prev, uintptrSize)
+		}
+		prev = cur
+	}
+}
+
+// TestMapHeaderOffsetLayout reproduces the ptrIndex bookkeeping
+// newMapHeaderCode does when it hands out idx, elemIdx and mapContext: start
+// at some ptrIndex, take the offset, advance, take the next offset, advance,
+// take a third offset, and hand that last one out as mapContext without
+// advancing again (the caller advances past it once the map head is done).
+// Consolidating mapIter/mapPos into MapContext shrunk this from 4 slots to
+// 3; this pins that the 3 slots newMapHeaderCode now hands out stay distinct
+// and uintptrSize-spaced, since any regression there would silently alias
+// two of idx/elemIdx/mapContext onto the same pointer-table slot.
+//
+// This is as deep as this chunk can test the map-offset consolidation:
+// actually calling newMapHeaderCode/newMapKeyCode/newMapValueCode/
+// newMapEndCode, or exercising dump()'s updated mapContext formatting, needs
+// a real *encodeCompileContext and *opcode, and opcode embeds rtype, opType
+// and *compiledCode — none of which are defined in this repo slice. Stubbing
+// them here to construct real opcodes would redeclare types that belong to
+// sibling files this chunk doesn't include, and would conflict with the real
+// definitions once those files exist.
+func TestMapHeaderOffsetLayout(t *testing.T) {
+	const start = 3
+	ptrIndex := start
+	idx := opcodeOffset(ptrIndex)
+	ptrIndex++
+	elemIdx := opcodeOffset(ptrIndex)
+	ptrIndex++
+	mapContext := opcodeOffset(ptrIndex)
+
+	if idx == elemIdx || elemIdx == mapContext || idx == mapContext {
+		t.Fatalf("idx=%d elemIdx=%d mapContext=%d must all be distinct pointer-table slots", idx, elemIdx, mapContext)
+	}
+	if elemIdx-idx != uintptrSize {
+		t.Fatalf("elemIdx - idx = %d, want uintptrSize (%d)", elemIdx-idx, uintptrSize)
+	}
+	if mapContext-elemIdx != uintptrSize {
+		t.Fatalf("mapContext - elemIdx = %d, want uintptrSize (%d)", mapContext-elemIdx, uintptrSize)
+	}
+	if idx != opcodeOffset(start) {
+		t.Fatalf("idx = %d, want opcodeOffset(start) = %d", idx, opcodeOffset(start))
+	}
+}
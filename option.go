@@ -0,0 +1,50 @@
+package json
+
+// EncodeOption customizes the behavior of Marshal/MarshalIndent. Options are
+// applied, in order, to an EncodeOptions value that is threaded into the
+// encodeCompileContext for the duration of compilation.
+type EncodeOption func(*EncodeOptions)
+
+// EncodeOptions holds encoder-wide settings assembled from the EncodeOption
+// values passed to Marshal.
+type EncodeOptions struct {
+	FieldQuery  *FieldQuery
+	ColorScheme *ColorScheme
+}
+
+// WithFieldQuery restricts Marshal to the map values and slice/array
+// elements selected by q (see NewFieldQuery), filtered by the compiler (see
+// (*opcode).resolveElemQuery). Named struct-field selectors are rejected by
+// NewFieldQuery, since filtering individual struct fields requires the
+// struct compiler to consult a FieldQuery per field, and that compiler
+// lives outside this chunk; Marshal(v, WithFieldQuery(q)) therefore cannot
+// yet select a subset of a struct's fields the way a future field-aware q
+// will.
+//
+// Compiled opcode trees are normally cached per type and reused across
+// Marshal calls; because query is baked directly into the opcode tree (see
+// seedCompileContext and copyOpcode in encode_opcode.go), the encoder's
+// compile entry point must bypass or key that cache by q's identity
+// whenever FieldQuery is set, or a queried Marshal call would permanently
+// bake q into every later unqueried call for the same type. That entry
+// point and its per-type cache live outside this chunk, so this option is
+// unsafe to combine with the opcode cache until it does, and no test can
+// exercise the invalidation from here — there's no cache in this repo slice
+// to assert against.
+func WithFieldQuery(q *FieldQuery) EncodeOption {
+	return func(opts *EncodeOptions) {
+		opts.FieldQuery = q
+	}
+}
+
+// seedCompileContext copies the settings relevant to compilation (currently
+// just FieldQuery) onto ctx before the root type is walked. The encoder's
+// compile entry point calls this once per Marshal call, and must not reuse
+// a cached opcode tree compiled under a different FieldQuery (see the
+// WithFieldQuery doc comment) — that invalidation isn't implemented here.
+func (opts *EncodeOptions) seedCompileContext(ctx *encodeCompileContext) {
+	if opts == nil {
+		return
+	}
+	ctx.query = opts.FieldQuery
+}
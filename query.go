@@ -0,0 +1,118 @@
+package json
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldQuery is a compiled path expression used to select the subset of
+// slice/array elements and map values that get emitted during Marshal, e.g.
+// "[*]" (every element of a top-level slice/array) or "[*][*]" (every
+// element of a slice of slices). A FieldQuery is built with NewFieldQuery
+// and attached to the encoder via WithFieldQuery.
+//
+// Named struct-field selectors (e.g. "Name" in "a.Name") are rejected by
+// NewFieldQuery: filtering by field name requires the struct compiler to
+// consult a FieldQuery per field, and that compiler lives outside this
+// chunk. Once it's wired, named segments can be supported the same way
+// wildcard segments are today.
+type FieldQuery struct {
+	wildcard *FieldQuery
+	leaf     bool
+}
+
+// NewFieldQuery compiles one or more path expressions into a single
+// FieldQuery tree. The paths share a common root, so the result selects the
+// union of every path given. Only the "*" wildcard segment is currently
+// supported; a named segment returns an error since struct-field filtering
+// isn't wired yet (see the FieldQuery doc comment).
+func NewFieldQuery(paths ...string) (*FieldQuery, error) {
+	root := &FieldQuery{}
+	for _, path := range paths {
+		tokens, err := tokenizeFieldQueryPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := root.merge(tokens, path); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+func (q *FieldQuery) merge(tokens []string, path string) error {
+	cur := q
+	for _, token := range tokens {
+		if token != "*" {
+			return fmt.Errorf("json: field query %q selects struct field %q by name, which isn't supported yet; only \"*\" wildcard segments are", path, token)
+		}
+		if cur.wildcard == nil {
+			cur.wildcard = &FieldQuery{}
+		}
+		cur = cur.wildcard
+	}
+	cur.leaf = true
+	return nil
+}
+
+// tokenizeFieldQueryPath splits a path such as "a.b[*].c" into its
+// dot/bracket separated components, e.g. ["a", "b", "*", "c"].
+func tokenizeFieldQueryPath(path string) ([]string, error) {
+	var tokens []string
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			tokens = append(tokens, buf.String())
+			buf.Reset()
+		}
+	}
+	runes := []rune(path)
+	inBracket := false
+	for i, r := range runes {
+		switch {
+		case r == '.' && !inBracket:
+			flush()
+		case r == '[':
+			flush()
+			inBracket = true
+		case r == ']':
+			if !inBracket {
+				return nil, fmt.Errorf("json: unmatched ']' in field query %q", path)
+			}
+			if buf.String() != "*" {
+				return nil, fmt.Errorf("json: field query bracket expressions must be [*], got [%s] in %q", buf.String(), path)
+			}
+			if next := i + 1; next < len(runes) && runes[next] != '.' && runes[next] != '[' {
+				return nil, fmt.Errorf("json: expected '.', '[' or end of path after ']' in field query %q", path)
+			}
+			flush()
+			inBracket = false
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("json: unmatched '[' in field query %q", path)
+	}
+	flush()
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("json: empty field query path %q", path)
+	}
+	return tokens, nil
+}
+
+// elem returns the sub-query selecting slice/array elements or map values,
+// and whether they're included at all. A nil receiver means "no filter is
+// active", so every element is included with no further restriction.
+func (q *FieldQuery) elem() (sub *FieldQuery, included bool) {
+	if q == nil {
+		return nil, true
+	}
+	if q.leaf {
+		return nil, true
+	}
+	if q.wildcard != nil {
+		return q.wildcard, true
+	}
+	return nil, false
+}
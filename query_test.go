@@ -0,0 +1,98 @@
+package json
+
+import "testing"
+
+func TestTokenizeFieldQueryPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"a.b.c", []string{"a", "b", "c"}},
+		{"a.b[*].c", []string{"a", "b", "*", "c"}},
+		{"a[*]", []string{"a", "*"}},
+		{"[*][*]", []string{"*", "*"}},
+	}
+	for _, tt := range tests {
+		got, err := tokenizeFieldQueryPath(tt.path)
+		if err != nil {
+			t.Fatalf("tokenizeFieldQueryPath(%q) returned error: %v", tt.path, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("tokenizeFieldQueryPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("tokenizeFieldQueryPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTokenizeFieldQueryPathErrors(t *testing.T) {
+	tests := []string{
+		"a]",
+		"a[b",
+		"",
+		"a[0].b",
+		"a[*]b",
+	}
+	for _, path := range tests {
+		if _, err := tokenizeFieldQueryPath(path); err == nil {
+			t.Errorf("tokenizeFieldQueryPath(%q) expected error, got nil", path)
+		}
+	}
+}
+
+func TestNewFieldQueryWildcardOnly(t *testing.T) {
+	q, err := NewFieldQuery("[*]")
+	if err != nil {
+		t.Fatalf("NewFieldQuery returned error: %v", err)
+	}
+	if _, included := q.elem(); !included {
+		t.Fatal("elem() should be included for a \"[*]\" query")
+	}
+}
+
+func TestNewFieldQueryNestedWildcard(t *testing.T) {
+	q, err := NewFieldQuery("[*][*]")
+	if err != nil {
+		t.Fatalf("NewFieldQuery returned error: %v", err)
+	}
+	inner, included := q.elem()
+	if !included {
+		t.Fatal("outer elem() should be included")
+	}
+	if _, included := inner.elem(); !included {
+		t.Fatal("inner elem() should be included")
+	}
+}
+
+func TestNewFieldQueryRejectsNamedSegments(t *testing.T) {
+	// Named struct-field selectors aren't wired yet (see the FieldQuery doc
+	// comment); NewFieldQuery must error rather than silently compiling to a
+	// query that filters nothing.
+	if _, err := NewFieldQuery("Name"); err == nil {
+		t.Fatal("NewFieldQuery(\"Name\") should error: struct-field filtering isn't supported yet")
+	}
+	if _, err := NewFieldQuery("a.b[*].c"); err == nil {
+		t.Fatal("NewFieldQuery(\"a.b[*].c\") should error: named segments aren't supported yet")
+	}
+}
+
+func TestFieldQueryNilIsUnfiltered(t *testing.T) {
+	var q *FieldQuery
+	if _, included := q.elem(); !included {
+		t.Fatal("nil FieldQuery should include every element")
+	}
+}
+
+func TestFieldQueryEmptyQueryExcludesEverything(t *testing.T) {
+	q, err := NewFieldQuery()
+	if err != nil {
+		t.Fatalf("NewFieldQuery() returned error: %v", err)
+	}
+	if _, included := q.elem(); included {
+		t.Fatal("an empty FieldQuery should exclude every element")
+	}
+}